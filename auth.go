@@ -0,0 +1,58 @@
+package main
+
+import "net/http"
+
+// Principal identifies whoever is on the other end of a subscription, as
+// established by an Authenticator.
+type Principal struct {
+	UserID string
+	Roles  []string
+	Labels map[string]string
+}
+
+// Authenticator resolves a Principal from an incoming request. Returning a
+// non-nil error rejects the connection before it is registered with the
+// broker.
+type Authenticator func(*http.Request) (Principal, error)
+
+// authenticate runs broker.Authenticator if one is set, otherwise allows
+// every request through as the zero Principal.
+func (broker *Broker) authenticate(req *http.Request) (Principal, error) {
+	if broker.Authenticator == nil {
+		return Principal{}, nil
+	}
+	return broker.Authenticator(req)
+}
+
+// Audience decides whether a client should receive an Event, based on the
+// Principal it authenticated as. A nil Audience matches everyone.
+type Audience func(Principal) bool
+
+// AudienceUserIDs returns an Audience matching any of the given user ids.
+func AudienceUserIDs(ids ...string) Audience {
+	allowed := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		allowed[id] = struct{}{}
+	}
+	return func(p Principal) bool {
+		_, ok := allowed[p.UserID]
+		return ok
+	}
+}
+
+// AudienceRoles returns an Audience matching a Principal with any of the
+// given roles.
+func AudienceRoles(roles ...string) Audience {
+	allowed := make(map[string]struct{}, len(roles))
+	for _, role := range roles {
+		allowed[role] = struct{}{}
+	}
+	return func(p Principal) bool {
+		for _, role := range p.Roles {
+			if _, ok := allowed[role]; ok {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// clientSeq assigns each client a small, log-friendly id; monotonic but not
+// meaningful beyond distinguishing clients in a log stream.
+var clientSeq uint64
+
+// DropPolicy controls what the broker does when a client's diode can't
+// absorb another event without overwriting one the client hasn't read yet.
+type DropPolicy int
+
+const (
+	// DropOldest overwrites the client's oldest unread event and keeps
+	// going. The client sees a gap (reflected in DroppedEvents) but stays
+	// connected.
+	DropOldest DropPolicy = iota
+	// DisconnectSlowClient closes the client instead of overwriting
+	// anything, so every event a client does see is guaranteed delivered.
+	DisconnectSlowClient
+)
+
+// client is one subscriber's side of the broker: a lossy diode the broker
+// publishes into, and the plumbing needed to wake and stop its drain loop.
+type client struct {
+	ring   *diode
+	wake   chan struct{}
+	closed chan struct{}
+
+	// shutdownCh is closed once by the broker's Shutdown to tell this
+	// client's ServeHTTP to send a final "event: close" frame before it
+	// closes the client down, distinct from closed (which covers every
+	// other way a client goes away: disconnect, write error, ...).
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+
+	// writeMu serializes writes to the client's http.ResponseWriter between
+	// the drain loop and the heartbeat loop, which otherwise write
+	// concurrently.
+	writeMu sync.Mutex
+	// poked wakes the heartbeat loop whenever a real write happens, so the
+	// idle timer resets instead of firing a redundant ping.
+	poked chan struct{}
+
+	closeOnce     sync.Once
+	DroppedEvents uint64 // atomic; events overwritten before this client read them
+
+	// principal is who this client authenticated as; set once at
+	// registration and read-only afterwards, so it's safe to read from
+	// listen() without synchronization.
+	principal Principal
+
+	id uint64
+}
+
+// subscription is what ServeHTTP hands to the broker's newClients channel: a
+// freshly registered client along with the topics it wants to hear about.
+// registered receives the id of the last buffered event this client is
+// already considered caught up on, once listen() has subscribed it, so
+// ServeHTTP can replay history up to exactly that point without racing the
+// live feed for the same events.
+type subscription struct {
+	client     *client
+	topics     []string
+	registered chan uint64
+}
+
+func newClient(bufferSize int, principal Principal) *client {
+	return &client{
+		ring:       newDiode(bufferSize),
+		wake:       make(chan struct{}, 1),
+		closed:     make(chan struct{}),
+		poked:      make(chan struct{}, 1),
+		shutdownCh: make(chan struct{}),
+		principal:  principal,
+		id:         atomic.AddUint64(&clientSeq, 1),
+	}
+}
+
+// shutdown tells this client's ServeHTTP to send a final frame and close
+// down. Safe to call multiple times.
+func (c *client) shutdown() {
+	c.shutdownOnce.Do(func() { close(c.shutdownCh) })
+}
+
+// poke signals the heartbeat loop that a real write just happened, without
+// blocking.
+func (c *client) poke() {
+	select {
+	case c.poked <- struct{}{}:
+	default:
+	}
+}
+
+// close marks the client as done. Safe to call multiple times and from
+// multiple goroutines (a write error and a client disconnect can race).
+func (c *client) close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+// signal wakes the client's drain loop without blocking.
+func (c *client) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
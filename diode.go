@@ -0,0 +1,50 @@
+package main
+
+import "sync/atomic"
+
+// diode is a lossy, single-writer/single-reader ring buffer of Events,
+// analogous to github.com/tylertreat/go-diodes: writes never block, and a
+// reader that falls behind has its oldest unread entries silently
+// overwritten rather than stalling the writer.
+type diode struct {
+	buf      []atomic.Value // each slot holds a *Event
+	size     uint64
+	writeIdx uint64 // atomic: index of the next slot to write
+	readIdx  uint64 // atomic: index of the next slot the reader will consume
+}
+
+func newDiode(size int) *diode {
+	return &diode{buf: make([]atomic.Value, size), size: uint64(size)}
+}
+
+// full reports whether the next write would overwrite a slot the reader has
+// not yet consumed.
+func (d *diode) full() bool {
+	return atomic.LoadUint64(&d.writeIdx)-atomic.LoadUint64(&d.readIdx) >= d.size
+}
+
+// write stores evt in the next slot. It never blocks; if the reader has
+// fallen a full buffer behind, the oldest unread entry is overwritten.
+func (d *diode) write(evt Event) {
+	idx := atomic.AddUint64(&d.writeIdx, 1) - 1
+	e := evt
+	d.buf[idx%d.size].Store(&e)
+}
+
+// next returns the next unread event, if any, and advances the reader's
+// position. dropped counts how many events were skipped because the writer
+// had already overwritten them before the reader got to them.
+func (d *diode) next() (evt Event, dropped uint64, ok bool) {
+	readIdx := atomic.LoadUint64(&d.readIdx)
+	writeIdx := atomic.LoadUint64(&d.writeIdx)
+	if readIdx >= writeIdx {
+		return Event{}, 0, false
+	}
+	if writeIdx-readIdx > d.size {
+		dropped = writeIdx - d.size - readIdx
+		readIdx = writeIdx - d.size
+	}
+	evt = *d.buf[readIdx%d.size].Load().(*Event)
+	atomic.StoreUint64(&d.readIdx, readIdx+1)
+	return evt, dropped, true
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestDiodeDropsOldestWhenFull(t *testing.T) {
+	d := newDiode(2)
+	d.write(Event{ID: 1})
+	d.write(Event{ID: 2})
+	d.write(Event{ID: 3}) // overwrites ID 1 before it's ever read
+
+	evt, dropped, ok := d.next()
+	if !ok {
+		t.Fatal("expected an event")
+	}
+	if dropped != 1 {
+		t.Fatalf("expected 1 dropped event, got %d", dropped)
+	}
+	if evt.ID != 2 {
+		t.Fatalf("expected the oldest surviving event (ID 2), got %d", evt.ID)
+	}
+
+	evt, dropped, ok = d.next()
+	if !ok || dropped != 0 || evt.ID != 3 {
+		t.Fatalf("expected ID 3 with no further drops, got %+v dropped=%d ok=%v", evt, dropped, ok)
+	}
+
+	if _, _, ok := d.next(); ok {
+		t.Fatal("expected no more events once the diode is drained")
+	}
+}
+
+func TestDiodeFullReportsBeforeOverwrite(t *testing.T) {
+	d := newDiode(2)
+	if d.full() {
+		t.Fatal("empty diode should not be full")
+	}
+	d.write(Event{ID: 1})
+	d.write(Event{ID: 2})
+	if !d.full() {
+		t.Fatal("diode should be full once every slot holds an unread event")
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import "log/slog"
+
+// Logger is the structured logging surface the broker uses for connect,
+// disconnect, and drop events. *slog.Logger satisfies it.
+type Logger interface {
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// logger returns broker.Logger, falling back to slog's default logger so
+// the broker always logs something without requiring configuration.
+func (broker *Broker) logger() Logger {
+	if broker.Logger != nil {
+		return broker.Logger
+	}
+	return slog.Default()
+}
@@ -1,50 +1,539 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Defaults used when the corresponding Broker/NewServer knob is left zero.
+const (
+	defaultBufferSize        = 256              // replay ring buffer, in events
+	defaultClientBufferSize  = 64               // per-client diode, in events
+	defaultWriteTimeout      = 5 * time.Second  // per-write deadline to a client
+	defaultHeartbeatInterval = 15 * time.Second // idle time before a keep-alive ping
+	defaultRetryInterval     = 3 * time.Second  // reconnect backoff suggested to the client
+)
+
+// DefaultTopic is what a client subscribes to when it names none, and what
+// Publish uses when called without one. WildcardTopic subscribes a client to
+// every topic, for admin/debug consumers.
+const (
+	DefaultTopic  = ""
+	WildcardTopic = "*"
+)
+
+// Event is a single Server-Sent Event. ID is assigned by the Broker when the
+// event is published, so callers normally leave it zero. Topic is set by
+// Publish and used for routing; it isn't part of the wire format.
+type Event struct {
+	ID    uint64
+	Name  string
+	Data  string
+	Retry time.Duration
+	Topic string
+	// Audience, if set, restricts delivery to clients whose authenticated
+	// Principal it matches. Nil means everyone subscribed to Topic.
+	Audience Audience
+}
+
+// bufferedEvent pairs an Event with the time it was stored, so the ring
+// buffer can enforce RetentionWindow independently of BufferSize.
+type bufferedEvent struct {
+	Event
+	storedAt time.Time
+}
+
 // Broker contains all thinkgs for brokering.
 type Broker struct {
 	// Events are pushed to this channel by the main events-gathering routine.
-	Notifier chan []byte
-	// New client connections.
-	newClients chan chan []byte
+	Notifier chan Event
+	// New client connections, along with the topics they subscribe to.
+	newClients chan *subscription
 	// Closed client connections.
-	closingClients chan chan []byte
-	// Client connections registry.
-	clients map[chan []byte]bool
+	closingClients chan *client
+	// topics maps a topic name to the clients subscribed to it.
+	topics map[string]map[*client]struct{}
+	// clientTopics is the reverse index of topics, so a disconnecting
+	// client can be removed from every topic it joined without a scan.
+	clientTopics map[*client]map[string]struct{}
+
+	// BufferSize is the maximum number of past events kept for
+	// Last-Event-ID replay. Zero means defaultBufferSize.
+	BufferSize int
+	// RetentionWindow, if non-zero, additionally drops buffered events
+	// older than this duration regardless of BufferSize.
+	RetentionWindow time.Duration
+
+	// ClientBufferSize is the size of each client's per-connection diode.
+	// A client that falls this many events behind starts losing events (or
+	// gets disconnected, per DropPolicy) instead of stalling the broker.
+	ClientBufferSize int
+	// WriteTimeout bounds how long a single write to a client's HTTP
+	// response may take before that client is treated as stalled.
+	WriteTimeout time.Duration
+	// DropPolicy decides what happens when a client can't keep up.
+	DropPolicy DropPolicy
+
+	// HeartbeatInterval is how long a client may go without a real write
+	// before the broker sends a ": ping" comment to keep idle proxies from
+	// closing the connection. Zero means defaultHeartbeatInterval.
+	HeartbeatInterval time.Duration
+	// RetryInterval is sent as a one-time "retry:" frame when a client
+	// connects, so browsers know how long to wait before reconnecting.
+	// Zero means defaultRetryInterval.
+	RetryInterval time.Duration
+
+	// Authenticator resolves a Principal for each connecting client. Nil
+	// means every client connects as the zero Principal.
+	Authenticator Authenticator
+	// AllowedOrigins is the set of Origin values the broker sends CORS
+	// headers for. Nil means no CORS headers are sent at all - safer than
+	// a wildcard now that streams can carry authenticated, per-user data.
+	AllowedOrigins []string
+
+	// Logger receives structured connect/disconnect/drop events. Nil falls
+	// back to slog's default logger.
+	Logger Logger
+	// Metrics, if set, additionally receives latency samples Stats doesn't
+	// capture. See the Metrics type.
+	Metrics Metrics
+
+	historyMu sync.Mutex
+	history   []bufferedEvent
+	nextID    uint64
+
+	// Built-in counters backing Stats; always maintained regardless of
+	// whether Metrics is set.
+	connectedClients   int64
+	eventsPublished    uint64
+	eventsDroppedTotal uint64
+	bytesWritten       uint64
+
+	// closed is 1 once Shutdown has been called; ServeHTTP checks it to
+	// stop accepting new subscribers.
+	closed int32
+	// done is closed by Shutdown to tell listen() to wind down.
+	done chan struct{}
+	// stopped is closed by listen() once it has finished notifying every
+	// client and exited, so Shutdown knows every client has been told to
+	// wind down.
+	stopped chan struct{}
+}
+
+// Publish sends evt to every client subscribed to topic (plus every
+// WildcardTopic subscriber). Callers that don't care about topics can pass
+// DefaultTopic.
+func (broker *Broker) Publish(topic string, evt Event) {
+	evt.Topic = topic
+	broker.Notifier <- evt
+}
+
+// PublishBytes sends raw bytes as a Data-only Event on DefaultTopic. It's a
+// compatibility shim for callers written before events gained types, ids,
+// and topics; topic-aware callers should use Publish directly.
+func (broker *Broker) PublishBytes(data []byte) {
+	broker.Publish(DefaultTopic, Event{Data: string(data)})
 }
 
 // Listen on different channels and act accordingly.
 func (broker *Broker) listen() {
 	for {
 		select {
-		case s := <-broker.newClients:
-			// A new client has connected.
-			broker.clients[s] = true
-			log.Printf("Client added. %d registered clients", len(broker.clients))
+		case sub := <-broker.newClients:
+			cutoff := broker.subscribe(sub.client, sub.topics)
+			atomic.AddInt64(&broker.connectedClients, 1)
+			if sub.registered != nil {
+				sub.registered <- cutoff
+			}
 
-		case s := <-broker.closingClients:
-			// A client has detached. Stop sending them messages.
-			delete(broker.clients, s)
-			log.Printf("Removed client. %d registered clients", len(broker.clients))
+		case c := <-broker.closingClients:
+			broker.unsubscribe(c)
+			atomic.AddInt64(&broker.connectedClients, -1)
 
 		case event := <-broker.Notifier:
-			// We got a new event from outside!
-			// Send event to all connected clients.
-			for clientMsgChan, _ := range broker.clients {
-				clientMsgChan <- event
+			// We got a new event from outside! Stamp it with the next id,
+			// buffer it for replay, and fan it out to every subscriber of
+			// its topic plus every wildcard subscriber.
+			start := time.Now()
+			event.ID = broker.storeEvent(event)
+			delivered := make(map[*client]bool, len(broker.topics[event.Topic]))
+			for c := range broker.topics[event.Topic] {
+				if event.Audience == nil || event.Audience(c.principal) {
+					broker.deliver(c, event)
+				}
+				delivered[c] = true
+			}
+			if event.Topic != WildcardTopic {
+				for c := range broker.topics[WildcardTopic] {
+					if !delivered[c] && (event.Audience == nil || event.Audience(c.principal)) {
+						broker.deliver(c, event)
+					}
+				}
 			}
+			atomic.AddUint64(&broker.eventsPublished, 1)
+			broker.observeFanout(time.Since(start))
+
+		case <-broker.done:
+			for c := range broker.clientTopics {
+				c.shutdown()
+			}
+			close(broker.stopped)
+			return
+		}
+	}
+}
+
+// Shutdown stops the broker from accepting new subscribers and tells every
+// remaining client to wind down: each client's handler sends a final
+// "event: close" frame and returns. Shutdown returns once every client has
+// been told, without waiting for those handlers to finish. Events still
+// queued in Notifier or in a client's diode at that point may be dropped
+// rather than delivered. It returns ctx.Err() if ctx is done first.
+func (broker *Broker) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&broker.closed, 0, 1) {
+		return nil // already shutting down
+	}
+	close(broker.done)
+
+	select {
+	case <-broker.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// subscribe registers c under each of topics, defaulting to DefaultTopic if
+// none were given, and records the reverse index used to clean it up later.
+// It returns the id of the most recent buffered event at the moment of
+// registration: since subscribe runs on the same single listen() goroutine
+// that assigns ids and fans out events, every event published after this
+// call returns is guaranteed to have a higher id and be delivered to c live,
+// so a caller can replay history up to this id without double-delivering
+// anything the live feed also picked up.
+func (broker *Broker) subscribe(c *client, topics []string) uint64 {
+	if len(topics) == 0 {
+		topics = []string{DefaultTopic}
+	}
+
+	joined := make(map[string]struct{}, len(topics))
+	for _, topic := range topics {
+		if broker.topics[topic] == nil {
+			broker.topics[topic] = make(map[*client]struct{})
+		}
+		broker.topics[topic][c] = struct{}{}
+		joined[topic] = struct{}{}
+	}
+	broker.clientTopics[c] = joined
+
+	broker.historyMu.Lock()
+	defer broker.historyMu.Unlock()
+	return broker.nextID
+}
+
+// unsubscribe removes c from every topic it joined.
+func (broker *Broker) unsubscribe(c *client) {
+	for topic := range broker.clientTopics[c] {
+		delete(broker.topics[topic], c)
+		if len(broker.topics[topic]) == 0 {
+			delete(broker.topics, topic)
+		}
+	}
+	delete(broker.clientTopics, c)
+}
+
+// deliver hands evt to a single client's diode without ever blocking
+// listen(): a client that is behind either loses its oldest unread event
+// or gets disconnected, per broker.DropPolicy, but the broadcast loop and
+// every other client are unaffected.
+func (broker *Broker) deliver(c *client, evt Event) {
+	if broker.DropPolicy == DisconnectSlowClient && c.ring.full() {
+		c.close()
+		return
+	}
+	c.ring.write(evt)
+	c.signal()
+}
+
+// storeEvent assigns the next monotonically increasing id to evt, appends it
+// to the ring buffer, and trims the buffer down to BufferSize / RetentionWindow.
+func (broker *Broker) storeEvent(evt Event) uint64 {
+	broker.historyMu.Lock()
+	defer broker.historyMu.Unlock()
+
+	broker.nextID++
+	evt.ID = broker.nextID
+
+	limit := broker.BufferSize
+	if limit <= 0 {
+		limit = defaultBufferSize
+	}
+
+	broker.history = append(broker.history, bufferedEvent{Event: evt, storedAt: time.Now()})
+	if len(broker.history) > limit {
+		broker.history = broker.history[len(broker.history)-limit:]
+	}
+	if broker.RetentionWindow > 0 {
+		cutoff := time.Now().Add(-broker.RetentionWindow)
+		i := 0
+		for i < len(broker.history) && broker.history[i].storedAt.Before(cutoff) {
+			i++
+		}
+		broker.history = broker.history[i:]
+	}
+
+	return evt.ID
+}
+
+// eventsSince returns every buffered event with lastID < id <= cutoff whose
+// topic is in topics (or any event at all, if topics contains
+// WildcardTopic), in publish order. cutoff caps the replay at the point the
+// client registered with the broker, so events published after that (and
+// delivered live instead) aren't replayed a second time.
+func (broker *Broker) eventsSince(lastID, cutoff uint64, topics []string) []Event {
+	broker.historyMu.Lock()
+	defer broker.historyMu.Unlock()
+
+	var oldest time.Time
+	if broker.RetentionWindow > 0 {
+		oldest = time.Now().Add(-broker.RetentionWindow)
+	}
+
+	var replay []Event
+	for _, buffered := range broker.history {
+		if buffered.storedAt.Before(oldest) {
+			continue
+		}
+		if buffered.ID > lastID && buffered.ID <= cutoff && subscribesTo(topics, buffered.Topic) {
+			replay = append(replay, buffered.Event)
+		}
+	}
+	return replay
+}
+
+// subscribesTo reports whether a client subscribed to topics would receive
+// an event published on topic.
+func subscribesTo(topics []string, topic string) bool {
+	for _, t := range topics {
+		if t == WildcardTopic || t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// lastEventID extracts the id the client last saw, from the Last-Event-ID
+// header (the value browsers send automatically on reconnect) or, failing
+// that, a ?lastEventId= query parameter.
+func lastEventID(req *http.Request) uint64 {
+	raw := req.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = req.URL.Query().Get("lastEventId")
+	}
+	id, _ := strconv.ParseUint(raw, 10, 64)
+	return id
+}
+
+// setCORSHeaders allows the request's Origin if it's in broker.AllowedOrigins,
+// with the Vary: Origin response varies correctly with caches. If
+// AllowedOrigins is empty, no CORS headers are sent.
+func (broker *Broker) setCORSHeaders(rw http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	for _, allowed := range broker.AllowedOrigins {
+		if allowed == origin {
+			rw.Header().Set("Access-Control-Allow-Origin", origin)
+			rw.Header().Add("Vary", "Origin")
+			return
+		}
+	}
+}
+
+// requestedTopics extracts the topics a client wants, from the
+// X-SSE-Topics header or, failing that, a ?topics= query parameter, both a
+// comma-separated list. No topics named means DefaultTopic.
+func requestedTopics(req *http.Request) []string {
+	raw := req.Header.Get("X-SSE-Topics")
+	if raw == "" {
+		raw = req.URL.Query().Get("topics")
+	}
+	if raw == "" {
+		return []string{DefaultTopic}
+	}
+
+	var topics []string
+	for _, t := range strings.Split(raw, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			topics = append(topics, t)
+		}
+	}
+	if len(topics) == 0 {
+		topics = []string{DefaultTopic}
+	}
+	return topics
+}
+
+// formatEvent renders evt using full SSE framing.
+func formatEvent(evt Event) string {
+	var b strings.Builder
+	if evt.ID != 0 {
+		fmt.Fprintf(&b, "id: %d\n", evt.ID)
+	}
+	if evt.Name != "" {
+		fmt.Fprintf(&b, "event: %s\n", evt.Name)
+	}
+	if evt.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", evt.Retry.Milliseconds())
+	}
+	for _, line := range strings.Split(evt.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// writeEvent writes evt to rw and flushes it, returning the number of bytes
+// written and any write error (notably a deadline exceeded error from a
+// stalled client).
+func writeEvent(rw http.ResponseWriter, flusher http.Flusher, evt Event) (int, error) {
+	n, err := io.WriteString(rw, formatEvent(evt))
+	if err != nil {
+		return n, err
+	}
+	flusher.Flush()
+	return n, nil
+}
+
+// writeComment writes an SSE comment line, used for keep-alive pings. Per
+// spec, a line starting with a colon is ignored by the client's EventSource
+// but still resets any idle timeout a proxy in between might enforce.
+func writeComment(rw http.ResponseWriter, flusher http.Flusher, comment string) (int, error) {
+	n, err := io.WriteString(rw, ": "+comment+"\n\n")
+	if err != nil {
+		return n, err
+	}
+	flusher.Flush()
+	return n, nil
+}
+
+// drain is the dedicated per-client goroutine that empties c's diode to its
+// HTTP response. It runs until c is closed: the client disconnected, a write
+// failed or missed its deadline, DropPolicy disconnected it, or the broker
+// shut down.
+func (broker *Broker) drain(c *client, rw http.ResponseWriter, flusher http.Flusher) {
+	rc := http.NewResponseController(rw)
+	timeout := broker.WriteTimeout
+	if timeout <= 0 {
+		timeout = defaultWriteTimeout
+	}
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-c.wake:
+		}
+
+		for {
+			evt, dropped, ok := c.ring.next()
+			if !ok {
+				// Clear the deadline left over from the last write so it
+				// doesn't go stale while the diode is empty; heartbeat sets
+				// its own deadline before each ping it sends in the meantime.
+				rc.SetWriteDeadline(time.Time{})
+				break
+			}
+			if dropped > 0 {
+				atomic.AddUint64(&c.DroppedEvents, dropped)
+				atomic.AddUint64(&broker.eventsDroppedTotal, dropped)
+				broker.logger().Warn("client dropped events", "client_id", c.id, "count", dropped)
+			}
+			rc.SetWriteDeadline(time.Now().Add(timeout))
+			start := time.Now()
+			c.writeMu.Lock()
+			n, err := writeEvent(rw, flusher, evt)
+			c.writeMu.Unlock()
+			broker.observeWrite(time.Since(start))
+			if err != nil {
+				broker.logger().Info("disconnecting slow client", "client_id", c.id, "error", err)
+				c.close()
+				return
+			}
+			atomic.AddUint64(&broker.bytesWritten, uint64(n))
+			c.poke()
+		}
+	}
+}
+
+// heartbeat keeps idle proxies (nginx, Cloudflare, ...) from dropping a
+// connection that has gone quiet by sending an SSE comment every
+// HeartbeatInterval. Any real write by drain resets the idle timer via
+// c.poke, so pings are only sent when nothing else has been.
+func (broker *Broker) heartbeat(c *client, rw http.ResponseWriter, flusher http.Flusher) {
+	interval := broker.HeartbeatInterval
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	timeout := broker.WriteTimeout
+	if timeout <= 0 {
+		timeout = defaultWriteTimeout
+	}
+	rc := http.NewResponseController(rw)
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+
+		case <-c.poked:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(interval)
+
+		case <-timer.C:
+			rc.SetWriteDeadline(time.Now().Add(timeout))
+			c.writeMu.Lock()
+			n, err := writeComment(rw, flusher, "ping")
+			c.writeMu.Unlock()
+			if err != nil {
+				c.close()
+				return
+			}
+			atomic.AddUint64(&broker.bytesWritten, uint64(n))
+			timer.Reset(interval)
 		}
 	}
 }
 
 // Broker implements http.Handler to handle HTTP connections.
 func (broker *Broker) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if atomic.LoadInt32(&broker.closed) == 1 {
+		http.Error(rw, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	principal, err := broker.authenticate(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
 	// Check if we can flush buffered data down the connection as it comes.
 	flusher, ok := rw.(http.Flusher)
 	if !ok {
@@ -57,38 +546,112 @@ func (broker *Broker) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	rw.Header().Set("Content-Type", "text/event-stream")
 	rw.Header().Set("Cache-Control", "no-cache")
 	rw.Header().Set("Connection", "keep-alive")
-	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	broker.setCORSHeaders(rw, req)
 
-	// Each connection registers its own msg chan w/ the Broker's connections registry.
-	msgChan := make(chan []byte)
-	// Signal the broker that we have a new connection.
-	broker.newClients <- msgChan
-	// Ensure client is removed from the map of connected clients when handler exits.
+	// Each connection gets its own client w/ a diode buffering events the
+	// broker publishes. Register it with the broker under its requested topics.
+	topics := requestedTopics(req)
+	lastID := lastEventID(req)
+	c := newClient(broker.ClientBufferSize, principal)
+	registered := make(chan uint64, 1)
+	select {
+	case broker.newClients <- &subscription{client: c, topics: topics, registered: registered}:
+	case <-broker.done:
+		// listen() has already wound down and will never read newClients;
+		// registering now would hang forever, so bail out like a client
+		// that arrived after Shutdown's closed check above.
+		http.Error(rw, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	cutoff := <-registered
+	broker.logger().Info("client connected",
+		"client_id", c.id,
+		"remote_addr", req.RemoteAddr,
+		"user_agent", req.UserAgent(),
+		"last_event_id", lastID,
+	)
+	// Ensure client is removed from the map of connected clients when handler
+	// exits. listen() stops reading closingClients once broker.done fires
+	// (every remaining client was told to shut down on the way out), so fall back to
+	// done instead of blocking forever.
 	defer func() {
-		broker.closingClients <- msgChan
+		select {
+		case broker.closingClients <- c:
+		case <-broker.done:
+		}
+		broker.logger().Info("client disconnected", "client_id", c.id)
 	}()
 
-	// Listen to connection close and un-register msgChan
-	// notify := rw.(http.CloseNotifier).CloseNotify()
+	// Listen to connection close and unregister the client.
 	go func() {
-		<-req.Context().Done()
-		broker.closingClients <- msgChan
+		select {
+		case <-req.Context().Done():
+			c.close()
+		case <-c.closed:
+		}
 	}()
 
-	// block waiting for msgs broadcast on this connection's msgChan
-	for {
-		fmt.Fprintf(rw, "data: %s\n\n", <-msgChan)
-		// Flush the data out the buffer immediately instead of buffering it for later.
-		flusher.Flush()
+	// Tell the browser how long to wait before reconnecting, once, up front.
+	retry := broker.RetryInterval
+	if retry <= 0 {
+		retry = defaultRetryInterval
+	}
+	fmt.Fprintf(rw, "retry: %d\n\n", retry.Milliseconds())
+	flusher.Flush()
+
+	// Replay anything the client missed since Last-Event-ID before joining
+	// the live broadcast below.
+	if lastID > 0 {
+		for _, evt := range broker.eventsSince(lastID, cutoff, topics) {
+			if evt.Audience != nil && !evt.Audience(principal) {
+				continue
+			}
+			if _, err := writeEvent(rw, flusher, evt); err != nil {
+				return
+			}
+		}
+	}
+
+	// Drain the client's diode to the HTTP response, and keep the
+	// connection alive with heartbeats while it's otherwise quiet. Both run
+	// on their own goroutine, isolated from every other client; this
+	// handler just blocks until the client is done.
+	go broker.drain(c, rw, flusher)
+	go broker.heartbeat(c, rw, flusher)
+
+	select {
+	case <-c.closed:
+	case <-c.shutdownCh:
+		c.writeMu.Lock()
+		writeEvent(rw, flusher, Event{Name: "close", Data: "server is shutting down"})
+		c.writeMu.Unlock()
+		c.close()
 	}
 }
 
-func NewServer() (broker *Broker) {
+// NewServer starts a Broker and its fan-out loop. clientBufferSize and
+// writeTimeout configure per-client delivery (zero picks the package
+// defaults); policy decides what happens to a client that can't keep up.
+func NewServer(clientBufferSize int, writeTimeout time.Duration, policy DropPolicy) (broker *Broker) {
+	if clientBufferSize <= 0 {
+		clientBufferSize = defaultClientBufferSize
+	}
+	if writeTimeout <= 0 {
+		writeTimeout = defaultWriteTimeout
+	}
+
 	broker = &Broker{
-		Notifier:       make(chan []byte, 1),
-		newClients:     make(chan chan []byte),
-		closingClients: make(chan chan []byte),
-		clients:        make(map[chan []byte]bool),
+		Notifier:         make(chan Event, 1),
+		newClients:       make(chan *subscription),
+		closingClients:   make(chan *client),
+		topics:           make(map[string]map[*client]struct{}),
+		clientTopics:     make(map[*client]map[string]struct{}),
+		BufferSize:       defaultBufferSize,
+		ClientBufferSize: clientBufferSize,
+		WriteTimeout:     writeTimeout,
+		DropPolicy:       policy,
+		done:             make(chan struct{}),
+		stopped:          make(chan struct{}),
 	}
 
 	go broker.listen()
@@ -97,17 +660,39 @@ func NewServer() (broker *Broker) {
 }
 
 func main() {
-	broker := NewServer()
+	broker := NewServer(defaultClientBufferSize, defaultWriteTimeout, DropOldest)
 
 	// Push events out to all clients at regular intervals.
 	go func() {
 		for {
 			time.Sleep(time.Second * 2)
 			eventString := fmt.Sprintf("the time is %v", time.Now())
-			log.Printf("Sending event to %d clients\n\n", len(broker.clients))
-			broker.Notifier <- []byte(eventString)
+			log.Printf("Sending event to %d clients\n\n", len(broker.clientTopics))
+			broker.Publish(DefaultTopic, Event{Data: eventString})
 		}
 	}()
 
-	log.Fatal("HTTP server error: ", http.ListenAndServe("localhost:8080", broker))
+	server := &http.Server{Addr: "localhost:8080", Handler: broker}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		log.Print("shutting down...")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := broker.Shutdown(shutdownCtx); err != nil {
+			log.Printf("broker shutdown: %v", err)
+		}
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("HTTP server shutdown: %v", err)
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal("HTTP server error: ", err)
+	}
 }
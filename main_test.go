@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEventsSinceExcludesEventsDeliveredLive guards against double delivery:
+// a client must receive everything published after it registers exactly
+// once, via the live feed, not again via replay.
+func TestEventsSinceExcludesEventsDeliveredLive(t *testing.T) {
+	broker := &Broker{
+		topics:       make(map[string]map[*client]struct{}),
+		clientTopics: make(map[*client]map[string]struct{}),
+	}
+
+	broker.storeEvent(Event{Data: "before registration"})
+
+	c := newClient(4, Principal{})
+	cutoff := broker.subscribe(c, []string{DefaultTopic})
+
+	// listen() would deliver this one live; eventsSince must not also
+	// replay it.
+	broker.storeEvent(Event{Data: "after registration"})
+
+	replay := broker.eventsSince(0, cutoff, []string{DefaultTopic})
+	if len(replay) != 1 || replay[0].Data != "before registration" {
+		t.Fatalf("expected only the pre-registration event replayed, got %+v", replay)
+	}
+}
+
+// TestShutdownDoesNotLeakClientHandlers is the regression test for the
+// deadlock this backlog review caught: ServeHTTP's deferred cleanup used to
+// block forever sending to closingClients once listen() had already wound
+// down, so the client's handler goroutine never returned and its response
+// body never ended.
+func TestShutdownDoesNotLeakClientHandlers(t *testing.T) {
+	broker := NewServer(4, 50*time.Millisecond, DropOldest)
+	srv := httptest.NewServer(broker)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	if _, err := reader.ReadString('\n'); err != nil { // retry: frame
+		t.Fatalf("reading retry frame: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := broker.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown did not complete: %v", err)
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading close frame: %v", err)
+	}
+	if !strings.Contains(string(body), "event: close") {
+		t.Fatalf("expected a final close frame, got %q", body)
+	}
+}
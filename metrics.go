@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point-in-time snapshot of broker activity. It's always
+// available via Broker.Stats, independent of whether Broker.Metrics is set.
+type Stats struct {
+	ConnectedClients int64
+	EventsPublished  uint64
+	EventsDropped    uint64
+	BytesWritten     uint64
+}
+
+// Stats returns a snapshot of the broker's built-in counters.
+func (broker *Broker) Stats() Stats {
+	return Stats{
+		ConnectedClients: atomic.LoadInt64(&broker.connectedClients),
+		EventsPublished:  atomic.LoadUint64(&broker.eventsPublished),
+		EventsDropped:    atomic.LoadUint64(&broker.eventsDroppedTotal),
+		BytesWritten:     atomic.LoadUint64(&broker.bytesWritten),
+	}
+}
+
+// Metrics is an optional hook for richer instrumentation than Stats covers,
+// namely the latency distributions a histogram-backed system (such as
+// Prometheus; see the prometheus build tag) can do something with. Nil
+// means these samples are simply dropped.
+type Metrics interface {
+	FanoutLatency(d time.Duration)
+	WriteLatency(d time.Duration)
+}
+
+// observeFanout reports how long it took to hand an event to every
+// subscriber, if a Metrics is configured.
+func (broker *Broker) observeFanout(d time.Duration) {
+	if broker.Metrics != nil {
+		broker.Metrics.FanoutLatency(d)
+	}
+}
+
+// observeWrite reports how long a single write to a client took, if a
+// Metrics is configured.
+func (broker *Broker) observeWrite(d time.Duration) {
+	if broker.Metrics != nil {
+		broker.Metrics.WriteLatency(d)
+	}
+}
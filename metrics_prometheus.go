@@ -0,0 +1,51 @@
+//go:build prometheus
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusCollector is a Metrics implementation that also satisfies
+// prometheus.Collector, so it can be registered with a prometheus.Registry
+// and fed into Broker.Metrics at the same time. It's only compiled in with
+// the "prometheus" build tag, so a default build never pulls in the
+// client_golang dependency.
+type PrometheusCollector struct {
+	fanoutLatency prometheus.Histogram
+	writeLatency  prometheus.Histogram
+}
+
+// NewPrometheusCollector builds a PrometheusCollector with the given metric
+// namespace (e.g. "sse").
+func NewPrometheusCollector(namespace string) *PrometheusCollector {
+	return &PrometheusCollector{
+		fanoutLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "fanout_latency_seconds",
+			Help:      "Time to hand a published event to every subscriber.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		writeLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "client_write_latency_seconds",
+			Help:      "Time to write a single frame to one client's response.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+}
+
+func (c *PrometheusCollector) FanoutLatency(d time.Duration) { c.fanoutLatency.Observe(d.Seconds()) }
+func (c *PrometheusCollector) WriteLatency(d time.Duration)  { c.writeLatency.Observe(d.Seconds()) }
+
+func (c *PrometheusCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.fanoutLatency.Describe(ch)
+	c.writeLatency.Describe(ch)
+}
+
+func (c *PrometheusCollector) Collect(ch chan<- prometheus.Metric) {
+	c.fanoutLatency.Collect(ch)
+	c.writeLatency.Collect(ch)
+}